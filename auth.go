@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	UserPassAuthVersion = 0x01
+
+	UserPassAuthSuccess = 0x00
+	UserPassAuthFailure = 0x01
+)
+
+// AuthContext carries the outcome of a successful method negotiation so
+// later stages (logging, ACLs) can tell which method was used and, for
+// username/password auth, who authenticated.
+type AuthContext struct {
+	Method  byte
+	Payload map[string]string
+}
+
+// Authenticator is implemented by every supported SOCKS5 auth method.
+// GetCode returns the method identifier sent during negotiation (see the
+// ConnMethod* constants); Authenticate performs whatever sub-negotiation
+// that method requires once it has been selected.
+type Authenticator interface {
+	GetCode() byte
+	Authenticate(reader io.Reader, writer io.Writer) (*AuthContext, error)
+}
+
+// NoAuthAuthenticator implements the "no authentication required" method.
+type NoAuthAuthenticator struct{}
+
+func (a NoAuthAuthenticator) GetCode() byte {
+	return ConnMethodNoAuth
+}
+
+func (a NoAuthAuthenticator) Authenticate(reader io.Reader, writer io.Writer) (*AuthContext, error) {
+	return &AuthContext{Method: ConnMethodNoAuth}, nil
+}
+
+// CredentialStore validates a username/password pair for UserPassAuthenticator.
+type CredentialStore interface {
+	Valid(user, password string) bool
+}
+
+// StaticCredentials is a simple map-backed CredentialStore, keyed by username.
+type StaticCredentials map[string]string
+
+func (s StaticCredentials) Valid(user, password string) bool {
+	pass, ok := s[user]
+	if !ok {
+		return false
+	}
+	return pass == password
+}
+
+// UserPassAuthenticator implements RFC 1929 username/password authentication.
+type UserPassAuthenticator struct {
+	Credentials CredentialStore
+}
+
+func (a UserPassAuthenticator) GetCode() byte {
+	return ConnMethodUsernamePassword
+}
+
+func (a UserPassAuthenticator) Authenticate(reader io.Reader, writer io.Writer) (*AuthContext, error) {
+	header, err := readBytes(reader, 1)
+	if err != nil {
+		return nil, err
+	}
+	if header[0] != UserPassAuthVersion {
+		return nil, fmt.Errorf("unsupported username/password auth version: %v", header[0])
+	}
+
+	ulen, err := readBytes(reader, 1)
+	if err != nil {
+		return nil, err
+	}
+	user, err := readBytes(reader, int(ulen[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	plen, err := readBytes(reader, 1)
+	if err != nil {
+		return nil, err
+	}
+	password, err := readBytes(reader, int(plen[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	if !a.Credentials.Valid(string(user), string(password)) {
+		if _, err := writer.Write([]byte{UserPassAuthVersion, UserPassAuthFailure}); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("invalid username or password")
+	}
+
+	if _, err := writer.Write([]byte{UserPassAuthVersion, UserPassAuthSuccess}); err != nil {
+		return nil, err
+	}
+
+	return &AuthContext{
+		Method:  ConnMethodUsernamePassword,
+		Payload: map[string]string{"Username": string(user)},
+	}, nil
+}
+
+// authenticate reads the client's offered methods (see protocol TConnReq{}),
+// picks the first one matching a configured Authenticator, writes the
+// selected method back to the client (see protocol TConnRsp{}) and runs
+// that authenticator's sub-negotiation. It writes {Sock5Version,
+// ConnMethodNoAcceptMethods} and returns an error when none match.
+func (s *Server) authenticate(conn io.Writer, bufConn io.Reader) (*AuthContext, error) {
+	buf2, err := readBytes(bufConn, 2)
+	if err != nil {
+		return nil, err
+	}
+	if buf2[0] != Sock5Version {
+		return nil, fmt.Errorf("unsupported SOCKS version: %v", buf2[0])
+	}
+
+	methods, err := readBytes(bufConn, int(buf2[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, authenticator := range s.AuthMethods {
+		for _, method := range methods {
+			if authenticator.GetCode() != method {
+				continue
+			}
+			if _, err := conn.Write([]byte{Sock5Version, authenticator.GetCode()}); err != nil {
+				return nil, err
+			}
+			return authenticator.Authenticate(bufConn, conn)
+		}
+	}
+
+	conn.Write([]byte{Sock5Version, ConnMethodNoAcceptMethods})
+	return nil, errors.New("no supported authentication mechanism")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAuthenticateNoAuth(t *testing.T) {
+	server, err := New(WithAuthMethods(NoAuthAuthenticator{}))
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+
+	req := bytes.NewBuffer([]byte{Sock5Version, 0x01, ConnMethodNoAuth})
+	rsp := &bytes.Buffer{}
+
+	authContext, err := server.authenticate(rsp, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if authContext.Method != ConnMethodNoAuth {
+		t.Fatalf("expected method %v, got %v", ConnMethodNoAuth, authContext.Method)
+	}
+	if !bytes.Equal(rsp.Bytes(), []byte{Sock5Version, ConnMethodNoAuth}) {
+		t.Fatalf("unexpected response bytes: %v", rsp.Bytes())
+	}
+}
+
+func TestAuthenticateMixedMethodsPrefersUserPass(t *testing.T) {
+	server, err := New(WithAuthMethods(
+		UserPassAuthenticator{Credentials: StaticCredentials{"foo": "bar"}},
+		NoAuthAuthenticator{},
+	))
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+
+	req := bytes.NewBuffer([]byte{Sock5Version, 0x02, ConnMethodNoAuth, ConnMethodUsernamePassword})
+	req.Write([]byte{UserPassAuthVersion, 3, 'f', 'o', 'o', 3, 'b', 'a', 'r'})
+	rsp := &bytes.Buffer{}
+
+	authContext, err := server.authenticate(rsp, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if authContext.Method != ConnMethodUsernamePassword {
+		t.Fatalf("expected method %v, got %v", ConnMethodUsernamePassword, authContext.Method)
+	}
+	if authContext.Payload["Username"] != "foo" {
+		t.Fatalf("expected username foo, got %v", authContext.Payload["Username"])
+	}
+
+	expected := []byte{Sock5Version, ConnMethodUsernamePassword, UserPassAuthVersion, UserPassAuthSuccess}
+	if !bytes.Equal(rsp.Bytes(), expected) {
+		t.Fatalf("unexpected response bytes: %v", rsp.Bytes())
+	}
+}
+
+func TestAuthenticateUserPassBadCredentials(t *testing.T) {
+	server, err := New(WithAuthMethods(UserPassAuthenticator{Credentials: StaticCredentials{"foo": "bar"}}))
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+
+	req := bytes.NewBuffer([]byte{Sock5Version, 0x01, ConnMethodUsernamePassword})
+	req.Write([]byte{UserPassAuthVersion, 3, 'f', 'o', 'o', 5, 'w', 'r', 'o', 'n', 'g'})
+	rsp := &bytes.Buffer{}
+
+	_, err = server.authenticate(rsp, req)
+	if err == nil {
+		t.Fatalf("expected error for bad credentials")
+	}
+
+	expected := []byte{Sock5Version, ConnMethodUsernamePassword, UserPassAuthVersion, UserPassAuthFailure}
+	if !bytes.Equal(rsp.Bytes(), expected) {
+		t.Fatalf("unexpected response bytes: %v", rsp.Bytes())
+	}
+}
+
+func TestAuthenticateNoMatchingMethod(t *testing.T) {
+	server, err := New(WithAuthMethods(UserPassAuthenticator{Credentials: StaticCredentials{"foo": "bar"}}))
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+
+	req := bytes.NewBuffer([]byte{Sock5Version, 0x01, ConnMethodNoAuth})
+	rsp := &bytes.Buffer{}
+
+	_, err = server.authenticate(rsp, req)
+	if err == nil {
+		t.Fatalf("expected error when no method matches")
+	}
+	if !bytes.Equal(rsp.Bytes(), []byte{Sock5Version, ConnMethodNoAcceptMethods}) {
+		t.Fatalf("unexpected response bytes: %v", rsp.Bytes())
+	}
+}
+
+func TestNewRejectsUserPassAuthenticatorWithoutCredentials(t *testing.T) {
+	if _, err := New(WithAuthMethods(UserPassAuthenticator{})); err == nil {
+		t.Fatalf("expected New to reject a UserPassAuthenticator with nil Credentials")
+	}
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/MDGSF/sock5/statute"
+)
+
+// wireAddr is an address already encoded the way a SOCKS5 request line
+// expects: an ATYP byte plus its address bytes (length-prefixed for domain
+// names) and a port.
+type wireAddr struct {
+	atyp  byte
+	bytes []byte
+	port  int
+}
+
+// encodeAddress converts a "host:port" string into wire format, choosing
+// IPv4, IPv6, or domain name depending on how host parses.
+func encodeAddress(address string) (*wireAddr, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &wireAddr{atyp: statute.AtypIPv4, bytes: ip4, port: port}, nil
+		}
+		return &wireAddr{atyp: statute.AtypIPv6, bytes: ip.To16(), port: port}, nil
+	}
+
+	if len(host) > 255 {
+		return nil, fmt.Errorf("client: domain name too long: %v", host)
+	}
+	return &wireAddr{
+		atyp:  statute.AtypDomainName,
+		bytes: append([]byte{byte(len(host))}, host...),
+		port:  port,
+	}, nil
+}
+
+// readAddrSpec reads a BND.ADDR/BND.PORT (or DST.ADDR/DST.PORT) pair off
+// the wire for the given ATYP.
+func readAddrSpec(r io.Reader, atyp byte) (*statute.AddrSpec, error) {
+	switch atyp {
+	case statute.AtypIPv4:
+		buf := make([]byte, net.IPv4len+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, buf[:net.IPv4len])
+		return &statute.AddrSpec{IP: ip, Port: int(buf[net.IPv4len])<<8 | int(buf[net.IPv4len+1])}, nil
+
+	case statute.AtypIPv6:
+		buf := make([]byte, net.IPv6len+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, buf[:net.IPv6len])
+		return &statute.AddrSpec{IP: ip, Port: int(buf[net.IPv6len])<<8 | int(buf[net.IPv6len+1])}, nil
+
+	case statute.AtypDomainName:
+		lenBuf := make([]byte, 1)
+		if _, err := io.ReadFull(r, lenBuf); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, int(lenBuf[0])+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		fqdn := string(buf[:lenBuf[0]])
+		port := int(buf[lenBuf[0]])<<8 | int(buf[lenBuf[0]+1])
+		return &statute.AddrSpec{FQDN: fqdn, Port: port}, nil
+
+	default:
+		return nil, fmt.Errorf("client: unsupported address type: %v", atyp)
+	}
+}
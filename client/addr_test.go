@@ -0,0 +1,37 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MDGSF/sock5/statute"
+)
+
+func TestEncodeAddressDomainName(t *testing.T) {
+	addr, err := encodeAddress("example.com:80")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addr.atyp != statute.AtypDomainName {
+		t.Fatalf("expected domain name atyp, got %v", addr.atyp)
+	}
+	if addr.port != 80 {
+		t.Fatalf("expected port 80, got %v", addr.port)
+	}
+	if !bytes.Equal(addr.bytes, append([]byte{11}, "example.com"...)) {
+		t.Fatalf("unexpected encoded bytes: %v", addr.bytes)
+	}
+}
+
+func TestEncodeAddressIPv4(t *testing.T) {
+	addr, err := encodeAddress("127.0.0.1:1080")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addr.atyp != statute.AtypIPv4 {
+		t.Fatalf("expected ipv4 atyp, got %v", addr.atyp)
+	}
+	if len(addr.bytes) != 4 {
+		t.Fatalf("expected 4 address bytes, got %v", len(addr.bytes))
+	}
+}
@@ -0,0 +1,228 @@
+// Package client implements a SOCKS5 client: method negotiation, optional
+// RFC 1929 username/password authentication, and CONNECT/UDP ASSOCIATE
+// dialing.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/MDGSF/sock5/statute"
+)
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithCredentials configures RFC 1929 username/password authentication.
+func WithCredentials(user, password string) Option {
+	return func(c *Client) {
+		c.user = user
+		c.password = password
+	}
+}
+
+// WithTCPTimeout bounds dialing and negotiating the TCP control connection.
+func WithTCPTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.tcpTimeout = timeout }
+}
+
+// WithUDPTimeout bounds reads on the PacketConn-like value Dial returns for
+// network "udp".
+func WithUDPTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.udpTimeout = timeout }
+}
+
+// WithVersion overrides the SOCKS version sent during negotiation. Defaults
+// to statute.VersionSocks5.
+func WithVersion(version byte) Option {
+	return func(c *Client) { c.version = version }
+}
+
+// Client dials destinations through a SOCKS5 proxy server.
+type Client struct {
+	server   string
+	version  byte
+	user     string
+	password string
+
+	tcpTimeout time.Duration
+	udpTimeout time.Duration
+}
+
+// NewClient creates a Client that talks to the SOCKS5 proxy listening at
+// server ("host:port"), configured by the given options.
+func NewClient(server string, opts ...Option) (*Client, error) {
+	if server == "" {
+		return nil, errors.New("client: server address is required")
+	}
+
+	c := &Client{server: server, version: statute.VersionSocks5}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Dial connects to address through the proxy. For "tcp" networks it issues
+// a CONNECT request and returns the resulting net.Conn. For "udp" networks
+// it issues a UDP ASSOCIATE request and returns a net.Conn that
+// encodes/decodes the SOCKS5 UDP header on every Write/Read.
+func (c *Client) Dial(network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return c.dialTCP(address)
+	case "udp", "udp4", "udp6":
+		return c.dialUDP(address)
+	default:
+		return nil, fmt.Errorf("client: unsupported network %q", network)
+	}
+}
+
+func (c *Client) dialTCP(address string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", c.server, c.tcpTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.tcpTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.tcpTimeout))
+	}
+
+	if err := c.negotiate(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if _, err := c.sendRequest(conn, statute.CommandConnect, address); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (c *Client) dialUDP(address string) (net.Conn, error) {
+	ctrl, err := net.DialTimeout("tcp", c.server, c.tcpTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.tcpTimeout > 0 {
+		ctrl.SetDeadline(time.Now().Add(c.tcpTimeout))
+	}
+
+	if err := c.negotiate(ctrl); err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	bnd, err := c.sendRequest(ctrl, statute.CommandUDPAssociate, "0.0.0.0:0")
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	ctrl.SetDeadline(time.Time{})
+
+	relay, err := net.Dial("udp", bnd.Address())
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	return &udpConn{Conn: relay, ctrl: ctrl, dstAddr: address, timeout: c.udpTimeout}, nil
+}
+
+// negotiate performs method negotiation (see protocol TConnReq{}/TConnRsp{})
+// and, if the server selects username/password, the RFC 1929
+// sub-negotiation.
+func (c *Client) negotiate(conn net.Conn) error {
+	methods := []byte{statute.MethodNoAuth}
+	if c.user != "" {
+		methods = []byte{statute.MethodUserPass}
+	}
+
+	req := make([]byte, 0, 2+len(methods))
+	req = append(req, c.version, byte(len(methods)))
+	req = append(req, methods...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	rsp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, rsp); err != nil {
+		return err
+	}
+	if rsp[0] != c.version {
+		return fmt.Errorf("client: unexpected version in method response: %v", rsp[0])
+	}
+
+	switch rsp[1] {
+	case statute.MethodNoAuth:
+		return nil
+	case statute.MethodUserPass:
+		return c.authenticateUserPass(conn)
+	case statute.MethodNoAcceptable:
+		return errors.New("client: server rejected all offered auth methods")
+	default:
+		return fmt.Errorf("client: server selected unsupported method: %v", rsp[1])
+	}
+}
+
+func (c *Client) authenticateUserPass(conn net.Conn) error {
+	req := make([]byte, 0, 3+len(c.user)+len(c.password))
+	req = append(req, statute.UserPassAuthVersion, byte(len(c.user)))
+	req = append(req, c.user...)
+	req = append(req, byte(len(c.password)))
+	req = append(req, c.password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	rsp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, rsp); err != nil {
+		return err
+	}
+	if rsp[1] != statute.UserPassAuthSuccess {
+		return errors.New("client: username/password authentication failed")
+	}
+	return nil
+}
+
+// sendRequest writes a request line (see protocol TConnDetailReq{}) for
+// command addressed to address and reads back the reply (see protocol
+// TConnDetailRsp{}), returning the BND address on success.
+func (c *Client) sendRequest(conn net.Conn, command byte, address string) (*statute.AddrSpec, error) {
+	addr, err := encodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	req := make([]byte, 0, 4+len(addr.bytes)+2)
+	req = append(req, c.version, command, 0x00, addr.atyp)
+	req = append(req, addr.bytes...)
+	req = append(req, byte(addr.port>>8), byte(addr.port))
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	return c.readReply(conn)
+}
+
+func (c *Client) readReply(conn net.Conn) (*statute.AddrSpec, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[0] != c.version {
+		return nil, fmt.Errorf("client: unexpected version in reply: %v", header[0])
+	}
+	if header[1] != statute.RepSucceeded {
+		return nil, fmt.Errorf("client: proxy refused request, reply code %#x", header[1])
+	}
+
+	return readAddrSpec(conn, header[3])
+}
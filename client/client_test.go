@@ -0,0 +1,44 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestDialTCPBoundsHandshakeByTCPTimeout verifies that WithTCPTimeout bounds
+// the whole method-negotiation/request handshake, not just the initial
+// net.Dial, by pointing the client at a server that accepts the connection
+// and then never replies.
+func TestDialTCPBoundsHandshakeByTCPTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		select {}
+	}()
+
+	c, err := NewClient(ln.Addr().String(), WithTCPTimeout(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("new client failed: %v", err)
+	}
+
+	start := time.Now()
+	_, err = c.Dial("tcp", "example.com:80")
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("expected dial to fail once the handshake deadline elapses")
+	}
+	if elapsed > time.Second {
+		t.Fatalf("dial took %v, expected it to be bounded by the 200ms tcp timeout", elapsed)
+	}
+}
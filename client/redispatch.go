@@ -0,0 +1,49 @@
+package client
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/MDGSF/sock5/statute"
+)
+
+// Request is the minimal parsed form of an inbound SOCKS5 request needed to
+// redispatch it through an upstream proxy.
+type Request struct {
+	Command     byte
+	Destination statute.AddrSpec
+}
+
+// Redispatch forwards an already-parsed inbound request through an
+// upstream SOCKS5 proxy listening at proxyAddr — this is what lets a
+// server chain through another proxy (e.g. Tor) instead of dialing the
+// destination directly. It returns the upstream connection and the BND
+// address the upstream proxy replied with.
+func Redispatch(proxyNet, proxyAddr string, req *Request) (net.Conn, *statute.AddrSpec, error) {
+	if req.Command != statute.CommandConnect {
+		return nil, nil, fmt.Errorf("client: redispatch does not support command %#x", req.Command)
+	}
+
+	c, err := NewClient(proxyAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := net.DialTimeout(proxyNet, c.server, c.tcpTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := c.negotiate(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	bnd, err := c.sendRequest(conn, statute.CommandConnect, req.Destination.Address())
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, bnd, nil
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"net"
+	"time"
+
+	"github.com/MDGSF/sock5/statute"
+)
+
+// udpConn wraps the UDP socket dialed to a proxy's UDP ASSOCIATE BND
+// address, adding the SOCKS5 UDP header on Write and stripping it on Read
+// so callers can use it like a normal net.Conn addressed to dstAddr.
+type udpConn struct {
+	net.Conn
+	ctrl    net.Conn
+	dstAddr string
+	timeout time.Duration
+}
+
+func (u *udpConn) Write(b []byte) (int, error) {
+	datagram, err := statute.NewDatagram(u.dstAddr, b)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := u.Conn.Write(datagram.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (u *udpConn) Read(b []byte) (int, error) {
+	if u.timeout > 0 {
+		u.Conn.SetReadDeadline(time.Now().Add(u.timeout))
+	}
+
+	buf := make([]byte, len(b)+statute.MaxUDPHeaderSize)
+	n, err := u.Conn.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	datagram, err := statute.ParseDatagram(buf[:n])
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, datagram.Data), nil
+}
+
+// Close tears down both the UDP relay socket and the TCP control
+// connection that keeps the association alive.
+func (u *udpConn) Close() error {
+	u.ctrl.Close()
+	return u.Conn.Close()
+}
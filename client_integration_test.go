@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MDGSF/sock5/client"
+	"github.com/MDGSF/sock5/statute"
+)
+
+func startEchoServer(t *testing.T) net.Listener {
+	t.Helper()
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen echo failed: %v", err)
+	}
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	return echo
+}
+
+func newTestServer(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+	server, err := New(opts...)
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+	return server
+}
+
+func startProxyServer(t *testing.T, server *Server) net.Listener {
+	t.Helper()
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen proxy failed: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := proxy.Accept()
+			if err != nil {
+				return
+			}
+			go server.handleConn(conn)
+		}
+	}()
+	return proxy
+}
+
+func echoThrough(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	msg := []byte("hello through proxy")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(buf) != string(msg) {
+		t.Fatalf("expected echo %q, got %q", msg, buf)
+	}
+}
+
+func TestClientDialConnectThroughServer(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	proxy := startProxyServer(t, newTestServer(t, WithAuthMethods(NoAuthAuthenticator{})))
+	defer proxy.Close()
+
+	c, err := client.NewClient(proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("new client failed: %v", err)
+	}
+
+	conn, err := c.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	echoThrough(t, conn)
+}
+
+func TestClientDialConnectWithCredentials(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	server := newTestServer(t, WithAuthMethods(UserPassAuthenticator{Credentials: StaticCredentials{"foo": "bar"}}))
+	proxy := startProxyServer(t, server)
+	defer proxy.Close()
+
+	c, err := client.NewClient(proxy.Addr().String(), client.WithCredentials("foo", "bar"))
+	if err != nil {
+		t.Fatalf("new client failed: %v", err)
+	}
+
+	conn, err := c.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	echoThrough(t, conn)
+}
+
+func TestRedispatchChainsThroughUpstreamProxy(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	upstream := startProxyServer(t, newTestServer(t, WithAuthMethods(NoAuthAuthenticator{})))
+	defer upstream.Close()
+
+	host, portStr, err := net.SplitHostPort(echo.Addr().String())
+	if err != nil {
+		t.Fatalf("split echo addr failed: %v", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatalf("parse echo port failed: %v", err)
+	}
+
+	req := &client.Request{
+		Command:     statute.CommandConnect,
+		Destination: statute.AddrSpec{IP: net.ParseIP(host), Port: port},
+	}
+
+	conn, _, err := client.Redispatch("tcp", upstream.Addr().String(), req)
+	if err != nil {
+		t.Fatalf("redispatch failed: %v", err)
+	}
+	defer conn.Close()
+
+	echoThrough(t, conn)
+}
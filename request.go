@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io"
+	"net"
+
+	"github.com/MDGSF/sock5/statute"
+)
+
+// Request carries everything known about an inbound connection between
+// parsing its SOCKS5 request line and dialing the destination: which
+// command was requested, who authenticated, and where it's headed.
+// AddressRewriter and RuleSet consult it to redirect or deny connections.
+type Request struct {
+	Version     byte
+	Command     byte
+	AuthContext *AuthContext
+	RemoteAddr  net.Addr
+
+	// DestAddr is the destination as parsed from the client's request.
+	DestAddr *statute.AddrSpec
+	// realDestAddr is DestAddr unless an AddressRewriter redirected it.
+	realDestAddr *statute.AddrSpec
+
+	bufConn io.Reader
+}
+
+// connDetailReqToAddrSpec converts the wire-level TConnDetailReq address
+// fields into a uniform statute.AddrSpec.
+func connDetailReqToAddrSpec(req *TConnDetailReq) *statute.AddrSpec {
+	addr := &statute.AddrSpec{Port: int(req.DstPort)}
+	if req.Atyp == ConnDetailReqAtypDomainName {
+		addr.FQDN = string(req.DstAddr)
+	} else {
+		addr.IP = net.IP(req.DstAddr)
+	}
+	return addr
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"context"
+
+	"github.com/MDGSF/sock5/statute"
+)
+
+// AddressRewriter lets callers transparently redirect a parsed request's
+// destination before it is dialed.
+type AddressRewriter interface {
+	Rewrite(ctx context.Context, req *Request) (context.Context, *statute.AddrSpec)
+}
+
+// RuleSet is consulted before dialing so callers can deny a request by
+// source IP, destination, or command.
+type RuleSet interface {
+	Allow(ctx context.Context, req *Request) (context.Context, bool)
+}
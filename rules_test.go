@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/MDGSF/sock5/client"
+	"github.com/MDGSF/sock5/statute"
+)
+
+type denyAllRuleSet struct{}
+
+func (denyAllRuleSet) Allow(ctx context.Context, req *Request) (context.Context, bool) {
+	return ctx, false
+}
+
+type redirectRewriter struct {
+	to statute.AddrSpec
+}
+
+func (r redirectRewriter) Rewrite(ctx context.Context, req *Request) (context.Context, *statute.AddrSpec) {
+	return ctx, &r.to
+}
+
+func TestHandleConnDeniedByRuleSet(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+
+	server, err := New(WithAuthMethods(NoAuthAuthenticator{}), WithRuleSet(denyAllRuleSet{}))
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+	proxy := startProxyServer(t, server)
+	defer proxy.Close()
+
+	c, err := client.NewClient(proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("new client failed: %v", err)
+	}
+
+	conn, err := c.Dial("tcp", echo.Addr().String())
+	if err == nil {
+		conn.Close()
+		t.Fatalf("expected dial to be denied by ruleset")
+	}
+}
+
+func TestHandleConnRewritesDestination(t *testing.T) {
+	real := startEchoServer(t)
+	defer real.Close()
+
+	decoy := startEchoServer(t)
+	defer decoy.Close()
+
+	host, portStr, err := net.SplitHostPort(real.Addr().String())
+	if err != nil {
+		t.Fatalf("split addr failed: %v", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatalf("parse port failed: %v", err)
+	}
+
+	server, err := New(
+		WithAuthMethods(NoAuthAuthenticator{}),
+		WithRewriter(redirectRewriter{to: statute.AddrSpec{IP: net.ParseIP(host), Port: port}}),
+	)
+	if err != nil {
+		t.Fatalf("new server failed: %v", err)
+	}
+	proxy := startProxyServer(t, server)
+	defer proxy.Close()
+
+	c, err := client.NewClient(proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("new client failed: %v", err)
+	}
+
+	conn, err := c.Dial("tcp", decoy.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	echoThrough(t, conn)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Server holds the configuration shared by every accepted connection.
+type Server struct {
+	// AuthMethods lists the authenticators offered to clients, in priority
+	// order. The first one matching a method the client offered is used.
+	AuthMethods []Authenticator
+
+	// Rewriter, if set, is consulted between parsing a request and
+	// dialing it, letting callers transparently redirect destinations.
+	Rewriter AddressRewriter
+
+	// Rules, if set, is consulted before dialing a request, letting
+	// callers deny connections by source IP, destination, or command.
+	Rules RuleSet
+
+	// UDPTimeout is how long a UDP ASSOCIATE client's upstream socket may
+	// sit idle before it is evicted. Defaults to DefaultUDPTimeout.
+	UDPTimeout time.Duration
+
+	// UDPBufferSize sizes the buffers used to read UDP datagrams.
+	// Defaults to DefaultUDPBufferSize.
+	UDPBufferSize int
+}
+
+// Option configures a Server constructed by New.
+type Option func(*Server)
+
+// WithAuthMethods sets the authenticators offered to clients. If omitted,
+// New falls back to NoAuthAuthenticator.
+func WithAuthMethods(methods ...Authenticator) Option {
+	return func(s *Server) { s.AuthMethods = methods }
+}
+
+// WithRewriter sets the AddressRewriter consulted before dialing.
+func WithRewriter(rewriter AddressRewriter) Option {
+	return func(s *Server) { s.Rewriter = rewriter }
+}
+
+// WithRuleSet sets the RuleSet consulted before dialing.
+func WithRuleSet(rules RuleSet) Option {
+	return func(s *Server) { s.Rules = rules }
+}
+
+// WithUDPTimeout sets Server.UDPTimeout.
+func WithUDPTimeout(timeout time.Duration) Option {
+	return func(s *Server) { s.UDPTimeout = timeout }
+}
+
+// WithUDPBufferSize sets Server.UDPBufferSize.
+func WithUDPBufferSize(size int) Option {
+	return func(s *Server) { s.UDPBufferSize = size }
+}
+
+// New creates a Server configured by the given options, defaulting to
+// NoAuthAuthenticator when no auth method is configured. It returns an
+// error if an option left the Server misconfigured, e.g. a
+// UserPassAuthenticator with no CredentialStore.
+func New(opts ...Option) (*Server, error) {
+	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if len(s.AuthMethods) == 0 {
+		s.AuthMethods = []Authenticator{NoAuthAuthenticator{}}
+	}
+	for _, authenticator := range s.AuthMethods {
+		if userPass, ok := authenticator.(UserPassAuthenticator); ok && userPass.Credentials == nil {
+			return nil, errors.New("sock5: UserPassAuthenticator requires a non-nil CredentialStore")
+		}
+	}
+	return s, nil
+}
+
+// ListenAndServe listens on the given network address and serves accepted
+// connections until Accept fails.
+func (s *Server) ListenAndServe(network, addr string) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts and serves connections from l until Accept fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
@@ -1,13 +1,13 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
-	"strings"
 	"sync/atomic"
 
 	"github.com/MDGSF/utils"
@@ -84,50 +84,27 @@ const (
 	ConnDetailRspAtypIPV6       = 0x04
 )
 
-func readConnReq(conn net.Conn) error {
-	//see protocol TConnReq{}
-	buf2 := readBytes(conn, 2)
-	if buf2[0] != Sock5Version {
-		log.Error("[]byte = %v", buf2)
-		panic("invalid version number")
-	}
-	//buf2[0] is version number.
-	//buf2[1] is the number of methods.
-
-	// buf3 is methods array, one method is one byte.
-	buf3 := readBytes(conn, int(buf2[1]))
-
-	support := false
-	for _, method := range buf3 {
-		if method == ConnMethodNoAuth {
-			support = true
-			break
-		}
-	}
-	if !support {
-		return errors.New("don't support")
-	}
-
-	return nil
-}
-
 func readConnDetailReq(conn net.Conn) (*TConnDetailReq, string, error) {
 	connDetailReq := &TConnDetailReq{}
-	buf4 := readBytes(conn, 4)
+	buf4, err := readBytes(conn, 4)
+	if err != nil {
+		return nil, "", err
+	}
 	if buf4[0] != Sock5Version {
-		log.Error("[]byte = %v", buf4)
-		panic("invalid version number")
+		conn.Write(genConnDetailRsp(ConnDetailRspRepGeneralServerFailure))
+		return nil, "", fmt.Errorf("unsupported SOCKS version: %v", buf4[0])
 	}
 	if buf4[2] != Sock5Rsv {
-		panic("invalid protocol")
+		conn.Write(genConnDetailRsp(ConnDetailRspRepGeneralServerFailure))
+		return nil, "", fmt.Errorf("invalid reserved byte: %v", buf4[2])
 	}
 
-	if buf4[1] != ConnDetailReqCmdConnect {
+	if buf4[1] != ConnDetailReqCmdConnect && buf4[1] != ConnDetailReqCmdUDP {
 		conn.Write(genConnDetailRsp(ConnDetailRspRepCmdNotSupported))
 		return nil, "", errors.New("not support command")
 	}
 
-	if buf4[3] != ConnDetailReqAtypIPV4 && buf4[3] != ConnDetailReqAtypDomainName {
+	if buf4[3] != ConnDetailReqAtypIPV4 && buf4[3] != ConnDetailReqAtypDomainName && buf4[3] != ConnDetailReqAtypIPV6 {
 		conn.Write(genConnDetailRsp(ConnDetailRspRepAddressTypeNotSupported))
 		return nil, "", errors.New("not support address type")
 	}
@@ -140,7 +117,10 @@ func readConnDetailReq(conn net.Conn) (*TConnDetailReq, string, error) {
 	var backenAddr string
 	if connDetailReq.Atyp == ConnDetailReqAtypIPV4 {
 
-		buf6 := readBytes(conn, 6)
+		buf6, err := readBytes(conn, 6)
+		if err != nil {
+			return nil, "", err
+		}
 		connDetailReq.DstAddr = make([]byte, 4)
 		connDetailReq.DstAddr[0] = buf6[0]
 		connDetailReq.DstAddr[1] = buf6[1]
@@ -152,16 +132,37 @@ func readConnDetailReq(conn net.Conn) (*TConnDetailReq, string, error) {
 
 	} else if connDetailReq.Atyp == ConnDetailReqAtypDomainName {
 
-		buf1 := readBytes(conn, 1)
+		buf1, err := readBytes(conn, 1)
+		if err != nil {
+			return nil, "", err
+		}
 		domainNameLen := int(buf1[0])
 
-		bufDomainName := readBytes(conn, domainNameLen)
+		bufDomainName, err := readBytes(conn, domainNameLen)
+		if err != nil {
+			return nil, "", err
+		}
 		connDetailReq.DstAddr = bufDomainName
 
-		buf2 := readBytes(conn, 2)
+		buf2, err := readBytes(conn, 2)
+		if err != nil {
+			return nil, "", err
+		}
 		connDetailReq.DstPort = uint16(buf2[0])*256 + uint16(buf2[1])
 
 		backenAddr = fmt.Sprintf("%s:%d", bufDomainName, connDetailReq.DstPort)
+
+	} else if connDetailReq.Atyp == ConnDetailReqAtypIPV6 {
+
+		buf18, err := readBytes(conn, 18)
+		if err != nil {
+			return nil, "", err
+		}
+		connDetailReq.DstAddr = make([]byte, net.IPv6len)
+		copy(connDetailReq.DstAddr, buf18[:net.IPv6len])
+		connDetailReq.DstPort = uint16(buf18[net.IPv6len])*256 + uint16(buf18[net.IPv6len+1])
+
+		backenAddr = fmt.Sprintf("[%s]:%d", net.IP(connDetailReq.DstAddr).String(), connDetailReq.DstPort)
 	}
 	return connDetailReq, backenAddr, nil
 }
@@ -178,36 +179,54 @@ func genConnDetailRsp(rep byte) []byte {
 	return []byte{rsp.Ver, rsp.Rep, rsp.Rsv, rsp.Atyp, rsp.BndAddr[0], rsp.BndAddr[1], rsp.BndAddr[2], rsp.BndAddr[3], 0x00, 0x00}
 }
 
-func writeConnDetailRspSuccess(conn net.Conn, backenAddr string) {
+func writeConnDetailRspSuccess(conn net.Conn, raddr net.Addr) error {
+	host, strport, err := net.SplitHostPort(raddr.String())
+	if err != nil {
+		conn.Write(genConnDetailRsp(ConnDetailRspRepGeneralServerFailure))
+		return fmt.Errorf("invalid backend address %q: %w", raddr.String(), err)
+	}
+
+	port, err := strconv.Atoi(strport)
+	if err != nil {
+		conn.Write(genConnDetailRsp(ConnDetailRspRepGeneralServerFailure))
+		return fmt.Errorf("invalid backend port %q: %w", strport, err)
+	}
+
+	_, err = conn.Write(encodeConnDetailRsp(ConnDetailRspRepSucceed, net.ParseIP(host), port))
+	return err
+}
+
+// encodeConnDetailRsp builds the wire bytes for a TConnDetailRsp carrying
+// ip/port as BND.ADDR/BND.PORT, picking ATYP IPv4 or IPv6 to match ip.
+func encodeConnDetailRsp(rep byte, ip net.IP, port int) []byte {
 	rsp := &TConnDetailRsp{
 		Ver:     Sock5Version,
-		Rep:     ConnDetailRspRepSucceed,
+		Rep:     rep,
 		Rsv:     Sock5Rsv,
-		Atyp:    ConnDetailRspAtypIPV4,
-		BndAddr: []byte{0x00, 0x00, 0x00, 0x00},
-		BndPort: 0,
+		BndPort: uint16(port),
 	}
 
-	pair := strings.Split(backenAddr, ":")
-	strip, strport := pair[0], pair[1]
-
-	copy(rsp.BndAddr, net.ParseIP(strip).To4())
-
-	port, err := strconv.Atoi(strport)
-	if err != nil {
-		panic("invalid port")
+	if ip4 := ip.To4(); ip4 != nil {
+		rsp.Atyp = ConnDetailRspAtypIPV4
+		rsp.BndAddr = ip4
+	} else {
+		rsp.Atyp = ConnDetailRspAtypIPV6
+		rsp.BndAddr = ip.To16()
 	}
-	rsp.BndPort = uint16(port)
 
-	conn.Write([]byte{rsp.Ver, rsp.Rep, rsp.Rsv, rsp.Atyp, rsp.BndAddr[0], rsp.BndAddr[1], rsp.BndAddr[2], rsp.BndAddr[3], byte(port / 256), byte(port % 256)})
+	out := make([]byte, 0, 4+len(rsp.BndAddr)+2)
+	out = append(out, rsp.Ver, rsp.Rep, rsp.Rsv, rsp.Atyp)
+	out = append(out, rsp.BndAddr...)
+	out = append(out, byte(port/256), byte(port%256))
+	return out
 }
 
-func readBytes(conn io.Reader, count int) []byte {
+func readBytes(conn io.Reader, count int) ([]byte, error) {
 	buf := make([]byte, count)
-	if n, err := io.ReadFull(conn, buf); err != nil || n != count {
-		panic(err)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
 	}
-	return buf
+	return buf, nil
 }
 
 func iobridge(src io.Reader, dst io.Writer) {
@@ -228,7 +247,7 @@ func iobridge(src io.Reader, dst io.Writer) {
 	}
 }
 
-func handleConn(conn net.Conn) {
+func (s *Server) handleConn(conn net.Conn) {
 	log.Info("accept new conn: %v, %v, %v", conn.RemoteAddr().Network(), conn.RemoteAddr().String(), atomic.AddInt32(&connectionNumber, 1))
 	defer func() {
 		if err := recover(); err != nil {
@@ -238,24 +257,60 @@ func handleConn(conn net.Conn) {
 		log.Info("close conn: %v, %v, %v", conn.RemoteAddr().Network(), conn.RemoteAddr().String(), atomic.AddInt32(&connectionNumber, -1))
 	}()
 
-	if err := readConnReq(conn); err != nil {
-		//writeConnRspFailed(conn), see protocol TConnRsp{}
-		conn.Write([]byte{Sock5Version, ConnMethodNoAcceptMethods})
+	authContext, err := s.authenticate(conn, conn)
+	if err != nil {
+		log.Error("authenticate failed, %v, %v, err = %v", conn.RemoteAddr().Network(), conn.RemoteAddr().String(), err)
 		return
 	}
-	//writeConnRspSuccess(conn), see protocol TConnRsp{}
-	conn.Write([]byte{Sock5Version, ConnMethodNoAuth})
+	if authContext.Payload != nil {
+		log.Info("authenticated as %v, %v, %v", authContext.Payload["Username"], conn.RemoteAddr().Network(), conn.RemoteAddr().String())
+	}
 
-	_, backenAddr, err := readConnDetailReq(conn)
+	connDetailReq, backenAddr, err := readConnDetailReq(conn)
 	if err != nil {
 		log.Error("read conn detail request failed, err = %v", err)
 		return
 	}
 
+	req := &Request{
+		Version:     Sock5Version,
+		Command:     connDetailReq.Cmd,
+		AuthContext: authContext,
+		RemoteAddr:  conn.RemoteAddr(),
+		DestAddr:    connDetailReqToAddrSpec(connDetailReq),
+		bufConn:     conn,
+	}
+	req.realDestAddr = req.DestAddr
+
+	ctx := context.Background()
+	if s.Rules != nil {
+		var allowed bool
+		ctx, allowed = s.Rules.Allow(ctx, req)
+		if !allowed {
+			conn.Write(genConnDetailRsp(ConnDetailRspRepNotAllowedByRuleset))
+			log.Error("connection denied by ruleset: %v -> %v", conn.RemoteAddr(), req.DestAddr)
+			return
+		}
+	}
+	if s.Rewriter != nil {
+		ctx, req.realDestAddr = s.Rewriter.Rewrite(ctx, req)
+	}
+	_ = ctx
+
+	if connDetailReq.Cmd == ConnDetailReqCmdUDP {
+		s.handleUDPAssociate(conn)
+		return
+	}
+
+	if req.realDestAddr != nil {
+		backenAddr = req.realDestAddr.Address()
+	}
+
 	log.Info("net dial backen address = %v", backenAddr)
 	backconn, err := net.Dial("tcp", backenAddr)
 	if err != nil {
 		log.Error("connect to backen addr %v failed, err = %v", backenAddr, err)
+		conn.Write(genConnDetailRsp(ConnDetailRspRepHostUnreachable))
 		return
 	}
 
@@ -265,7 +320,10 @@ func handleConn(conn net.Conn) {
 		log.Info("close backenconn: %v, %v", backconn.RemoteAddr().Network(), backconn.RemoteAddr().String())
 	}()
 
-	writeConnDetailRspSuccess(conn, backconn.RemoteAddr().String())
+	if err := writeConnDetailRspSuccess(conn, backconn.RemoteAddr()); err != nil {
+		log.Error("write conn detail response failed, err = %v", err)
+		return
+	}
 
 	go iobridge(conn, backconn)
 	iobridge(backconn, conn)
@@ -277,19 +335,14 @@ func main() {
 	addr := flag.String("addr", ":1080", "localhost:1080")
 	flag.Parse()
 
-	listener, err := net.Listen("tcp", *addr)
+	server, err := New(WithAuthMethods(NoAuthAuthenticator{}))
 	if err != nil {
-		log.Error("listen failed on %v, err = %v", *addr, err)
+		log.Error("configure server failed, err = %v", err)
 		return
 	}
-	log.Info("listen on %v", *addr)
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Error("accept failed, err = %v", err)
-			continue
-		}
-		go handleConn(conn)
+	log.Info("listen on %v", *addr)
+	if err := server.ListenAndServe("tcp", *addr); err != nil {
+		log.Error("serve failed on %v, err = %v", *addr, err)
 	}
 }
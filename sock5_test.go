@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func buildDetailReq(cmd, atyp byte, addr []byte, port uint16) []byte {
+	buf := []byte{Sock5Version, cmd, Sock5Rsv, atyp}
+	if atyp == ConnDetailReqAtypDomainName {
+		buf = append(buf, byte(len(addr)))
+	}
+	buf = append(buf, addr...)
+	buf = append(buf, byte(port>>8), byte(port))
+	return buf
+}
+
+func TestReadConnDetailReqTableDriven(t *testing.T) {
+	cases := []struct {
+		name     string
+		raw      []byte
+		wantAtyp byte
+		wantAddr string
+		wantPort uint16
+	}{
+		{
+			name:     "ipv4",
+			raw:      buildDetailReq(ConnDetailReqCmdConnect, ConnDetailReqAtypIPV4, []byte{127, 0, 0, 1}, 1080),
+			wantAtyp: ConnDetailReqAtypIPV4,
+			wantAddr: "127.0.0.1:1080",
+			wantPort: 1080,
+		},
+		{
+			name:     "ipv6",
+			raw:      buildDetailReq(ConnDetailReqCmdConnect, ConnDetailReqAtypIPV6, net.ParseIP("::1").To16(), 1080),
+			wantAtyp: ConnDetailReqAtypIPV6,
+			wantAddr: "[::1]:1080",
+			wantPort: 1080,
+		},
+		{
+			name:     "domain",
+			raw:      buildDetailReq(ConnDetailReqCmdConnect, ConnDetailReqAtypDomainName, []byte("example.com"), 1080),
+			wantAtyp: ConnDetailReqAtypDomainName,
+			wantAddr: "example.com:1080",
+			wantPort: 1080,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+
+			go client.Write(tc.raw)
+
+			req, addr, err := readConnDetailReq(server)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if req.Atyp != tc.wantAtyp {
+				t.Fatalf("expected atyp %v, got %v", tc.wantAtyp, req.Atyp)
+			}
+			if addr != tc.wantAddr {
+				t.Fatalf("expected addr %v, got %v", tc.wantAddr, addr)
+			}
+			if req.DstPort != tc.wantPort {
+				t.Fatalf("expected port %v, got %v", tc.wantPort, req.DstPort)
+			}
+		})
+	}
+}
+
+type fakeAddr string
+
+func (f fakeAddr) Network() string { return "tcp" }
+func (f fakeAddr) String() string  { return string(f) }
+
+func TestWriteConnDetailRspSuccessTableDriven(t *testing.T) {
+	cases := []struct {
+		name     string
+		raddr    net.Addr
+		wantAtyp byte
+		wantLen  int
+	}{
+		{"ipv4", fakeAddr("93.184.216.34:443"), ConnDetailRspAtypIPV4, net.IPv4len},
+		{"ipv6", fakeAddr("[2606:2800:220:1:248:1893:25c8:1946]:443"), ConnDetailRspAtypIPV6, net.IPv6len},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+
+			errCh := make(chan error, 1)
+			go func() { errCh <- writeConnDetailRspSuccess(server, tc.raddr) }()
+
+			rsp := make([]byte, 4+tc.wantLen+2)
+			if _, err := io.ReadFull(client, rsp); err != nil {
+				t.Fatalf("read reply failed: %v", err)
+			}
+			if err := <-errCh; err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+
+			if rsp[1] != ConnDetailRspRepSucceed {
+				t.Fatalf("expected succeeded reply, got %v", rsp[1])
+			}
+			if rsp[3] != tc.wantAtyp {
+				t.Fatalf("expected atyp %v, got %v", tc.wantAtyp, rsp[3])
+			}
+		})
+	}
+}
+
+// TestDomainRequestRespondsWithIPv6BindAddress exercises a domain-name
+// request whose backend connection resolves to an IPv6 peer, checking that
+// the reply's BND.ADDR correctly switches to ATYP 0x04.
+func TestDomainRequestRespondsWithIPv6BindAddress(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	raw := buildDetailReq(ConnDetailReqCmdConnect, ConnDetailReqAtypDomainName, []byte("example.com"), 443)
+	go client.Write(raw)
+
+	req, addr, err := readConnDetailReq(server)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if req.Atyp != ConnDetailReqAtypDomainName {
+		t.Fatalf("expected domain name atyp, got %v", req.Atyp)
+	}
+	if addr != "example.com:443" {
+		t.Fatalf("unexpected backend address: %v", addr)
+	}
+
+	backendV6 := fakeAddr("[2606:2800:220:1:248:1893:25c8:1946]:443")
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- writeConnDetailRspSuccess(server, backendV6) }()
+
+	rsp := make([]byte, 4+net.IPv6len+2)
+	if _, err := io.ReadFull(client, rsp); err != nil {
+		t.Fatalf("read reply failed: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if rsp[3] != ConnDetailRspAtypIPV6 {
+		t.Fatalf("expected IPv6 bind address atyp, got %v", rsp[3])
+	}
+}
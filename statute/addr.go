@@ -0,0 +1,31 @@
+package statute
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// AddrSpec uniformly represents a SOCKS5 destination or bind address,
+// whether it arrived as an IPv4, IPv6, or domain-name ATYP.
+type AddrSpec struct {
+	FQDN string
+	IP   net.IP
+	Port int
+}
+
+func (a *AddrSpec) String() string {
+	if a.FQDN != "" {
+		return fmt.Sprintf("%s (%s):%d", a.FQDN, a.IP, a.Port)
+	}
+	return fmt.Sprintf("%s:%d", a.IP, a.Port)
+}
+
+// Address returns a "host:port" string suitable for net.Dial, preferring
+// the domain name over the resolved IP when both are present.
+func (a *AddrSpec) Address() string {
+	if a.FQDN != "" {
+		return net.JoinHostPort(a.FQDN, strconv.Itoa(a.Port))
+	}
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+}
@@ -0,0 +1,45 @@
+package statute
+
+// VersionSocks5 is the SOCKS protocol version byte used throughout this
+// package.
+const VersionSocks5 = 0x05
+
+// Method negotiation identifiers, as sent in TConnReq/TConnRsp.
+const (
+	MethodNoAuth       = 0x00
+	MethodGSSAPI       = 0x01
+	MethodUserPass     = 0x02
+	MethodNoAcceptable = 0xFF
+)
+
+// RFC 1929 username/password sub-negotiation.
+const (
+	UserPassAuthVersion = 0x01
+	UserPassAuthSuccess = 0x00
+	UserPassAuthFailure = 0x01
+)
+
+// Request commands, as sent in TConnDetailReq.
+const (
+	CommandConnect      = 0x01
+	CommandBind         = 0x02
+	CommandUDPAssociate = 0x03
+)
+
+// MaxUDPHeaderSize is the largest a SOCKS5 UDP header (RSV, FRAG, ATYP,
+// DST.ADDR, DST.PORT) can be: a domain name address with a full 255-byte
+// length-prefixed name.
+const MaxUDPHeaderSize = 4 + 1 + 255 + 2
+
+// Reply codes, as sent in TConnDetailRsp.
+const (
+	RepSucceeded               = 0x00
+	RepGeneralServerFailure    = 0x01
+	RepNotAllowedByRuleset     = 0x02
+	RepNetworkUnreachable      = 0x03
+	RepHostUnreachable         = 0x04
+	RepConnectionRefused       = 0x05
+	RepTTLExpired              = 0x06
+	RepCommandNotSupported     = 0x07
+	RepAddressTypeNotSupported = 0x08
+)
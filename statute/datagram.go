@@ -0,0 +1,128 @@
+// Package statute holds SOCKS5 wire-format types shared by the server and
+// client implementations.
+package statute
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+const (
+	AtypIPv4       = 0x01
+	AtypDomainName = 0x03
+	AtypIPv6       = 0x04
+)
+
+// Datagram is a SOCKS5 UDP request/reply packet:
+//
+//	RSV(2) | FRAG(1) | ATYP(1) | DST.ADDR | DST.PORT(2) | DATA
+type Datagram struct {
+	Rsv     [2]byte
+	Frag    byte
+	Atyp    byte
+	DstAddr []byte
+	DstPort uint16
+	Data    []byte
+}
+
+// Address returns DST.ADDR:DST.PORT formatted for net.Dial/net.ResolveUDPAddr.
+func (d *Datagram) Address() string {
+	if d.Atyp == AtypDomainName {
+		return net.JoinHostPort(string(d.DstAddr), strconv.Itoa(int(d.DstPort)))
+	}
+	return net.JoinHostPort(net.IP(d.DstAddr).String(), strconv.Itoa(int(d.DstPort)))
+}
+
+// Bytes serializes the datagram back to wire format.
+func (d *Datagram) Bytes() []byte {
+	buf := make([]byte, 0, 4+1+len(d.DstAddr)+2+len(d.Data))
+	buf = append(buf, d.Rsv[0], d.Rsv[1], d.Frag, d.Atyp)
+	if d.Atyp == AtypDomainName {
+		buf = append(buf, byte(len(d.DstAddr)))
+	}
+	buf = append(buf, d.DstAddr...)
+	buf = append(buf, byte(d.DstPort>>8), byte(d.DstPort))
+	buf = append(buf, d.Data...)
+	return buf
+}
+
+// ParseDatagram parses a raw SOCKS5 UDP packet. Fragmented datagrams
+// (FRAG != 0) are rejected, since RFC 1928 permits dropping them.
+func ParseDatagram(b []byte) (Datagram, error) {
+	if len(b) < 4 {
+		return Datagram{}, errors.New("statute: short datagram")
+	}
+
+	d := Datagram{Rsv: [2]byte{b[0], b[1]}, Frag: b[2], Atyp: b[3]}
+	if d.Frag != 0 {
+		return Datagram{}, errors.New("statute: fragmented datagram not supported")
+	}
+	b = b[4:]
+
+	switch d.Atyp {
+	case AtypIPv4:
+		if len(b) < net.IPv4len+2 {
+			return Datagram{}, errors.New("statute: short ipv4 datagram")
+		}
+		d.DstAddr = b[:net.IPv4len]
+		b = b[net.IPv4len:]
+
+	case AtypIPv6:
+		if len(b) < net.IPv6len+2 {
+			return Datagram{}, errors.New("statute: short ipv6 datagram")
+		}
+		d.DstAddr = b[:net.IPv6len]
+		b = b[net.IPv6len:]
+
+	case AtypDomainName:
+		if len(b) < 1 {
+			return Datagram{}, errors.New("statute: short domain datagram")
+		}
+		length := int(b[0])
+		b = b[1:]
+		if len(b) < length+2 {
+			return Datagram{}, errors.New("statute: short domain datagram")
+		}
+		d.DstAddr = b[:length]
+		b = b[length:]
+
+	default:
+		return Datagram{}, fmt.Errorf("statute: unsupported address type: %v", d.Atyp)
+	}
+
+	d.DstPort = uint16(b[0])<<8 | uint16(b[1])
+	d.Data = b[2:]
+	return d, nil
+}
+
+// NewDatagram builds a Datagram addressed to dstAddr ("host:port", host may
+// be an IPv4 literal, IPv6 literal, or domain name) carrying payload as DATA.
+func NewDatagram(dstAddr string, payload []byte) (Datagram, error) {
+	host, portStr, err := net.SplitHostPort(dstAddr)
+	if err != nil {
+		return Datagram{}, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return Datagram{}, err
+	}
+
+	d := Datagram{DstPort: uint16(port), Data: payload}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		d.Atyp = AtypDomainName
+		d.DstAddr = []byte(host)
+	case ip.To4() != nil:
+		d.Atyp = AtypIPv4
+		d.DstAddr = ip.To4()
+	default:
+		d.Atyp = AtypIPv6
+		d.DstAddr = ip.To16()
+	}
+
+	return d, nil
+}
@@ -0,0 +1,51 @@
+package statute
+
+import "testing"
+
+func TestDatagramRoundTripIPv4(t *testing.T) {
+	d, err := NewDatagram("8.8.8.8:53", []byte("payload"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parsed, err := ParseDatagram(d.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if parsed.Address() != "8.8.8.8:53" {
+		t.Fatalf("expected 8.8.8.8:53, got %v", parsed.Address())
+	}
+	if string(parsed.Data) != "payload" {
+		t.Fatalf("expected payload, got %v", parsed.Data)
+	}
+}
+
+func TestDatagramRoundTripDomainName(t *testing.T) {
+	d, err := NewDatagram("example.com:80", []byte("x"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parsed, err := ParseDatagram(d.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if parsed.Address() != "example.com:80" {
+		t.Fatalf("expected example.com:80, got %v", parsed.Address())
+	}
+}
+
+func TestParseDatagramRejectsFragments(t *testing.T) {
+	d, err := NewDatagram("127.0.0.1:53", []byte("x"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	raw := d.Bytes()
+	raw[2] = 1 // FRAG != 0
+
+	if _, err := ParseDatagram(raw); err == nil {
+		t.Fatalf("expected fragmented datagram to be rejected")
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/MDGSF/sock5/statute"
+	"github.com/MDGSF/utils/log"
+)
+
+const (
+	DefaultUDPTimeout    = 60 * time.Second
+	DefaultUDPBufferSize = 64 * 1024
+)
+
+// udpAssociation relays datagrams for a single UDP ASSOCIATE session. Every
+// client sharing the association's server-facing socket gets its own
+// upstream socket, keyed by client source address, so return traffic can be
+// routed back to the right client.
+type udpAssociation struct {
+	serverConn *net.UDPConn
+	timeout    time.Duration
+	bufSize    int
+
+	mu      sync.Mutex
+	clients map[string]*udpClient
+}
+
+// udpClient is the upstream socket used to reach the real destinations a
+// single client's datagrams are addressed to.
+type udpClient struct {
+	addr       *net.UDPAddr
+	upstream   *net.UDPConn
+	lastActive time.Time
+}
+
+func newUDPAssociation(serverConn *net.UDPConn, timeout time.Duration, bufSize int) *udpAssociation {
+	if timeout <= 0 {
+		timeout = DefaultUDPTimeout
+	}
+	if bufSize <= 0 {
+		bufSize = DefaultUDPBufferSize
+	}
+	return &udpAssociation{
+		serverConn: serverConn,
+		timeout:    timeout,
+		bufSize:    bufSize,
+		clients:    make(map[string]*udpClient),
+	}
+}
+
+// relay reads datagrams from the client-facing socket, parses the SOCKS5
+// UDP header and forwards DATA to the real destination. It returns once
+// serverConn is closed.
+func (a *udpAssociation) relay() {
+	buf := make([]byte, a.bufSize)
+	for {
+		n, clientAddr, err := a.serverConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		datagram, err := statute.ParseDatagram(buf[:n])
+		if err != nil {
+			log.Error("udp associate: parse datagram from %v failed, err = %v", clientAddr, err)
+			continue
+		}
+
+		client, err := a.clientFor(clientAddr)
+		if err != nil {
+			log.Error("udp associate: open upstream for %v failed, err = %v", clientAddr, err)
+			continue
+		}
+
+		dstAddr, err := net.ResolveUDPAddr("udp", datagram.Address())
+		if err != nil {
+			log.Error("udp associate: resolve %v failed, err = %v", datagram.Address(), err)
+			continue
+		}
+
+		if _, err := client.upstream.WriteToUDP(datagram.Data, dstAddr); err != nil {
+			log.Error("udp associate: forward to %v failed, err = %v", dstAddr, err)
+		}
+	}
+}
+
+// clientFor returns the udpClient tracked for clientAddr, creating its
+// upstream socket and reply pump on first use.
+func (a *udpAssociation) clientFor(clientAddr *net.UDPAddr) (*udpClient, error) {
+	key := clientAddr.String()
+
+	a.mu.Lock()
+	client, ok := a.clients[key]
+	a.mu.Unlock()
+	if ok {
+		a.touch(key)
+		return client, nil
+	}
+
+	upstream, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client = &udpClient{addr: clientAddr, upstream: upstream, lastActive: time.Now()}
+
+	a.mu.Lock()
+	a.clients[key] = client
+	a.mu.Unlock()
+
+	go a.pumpReplies(key, client)
+	go a.evictWhenIdle(key, client)
+
+	return client, nil
+}
+
+// pumpReplies reads return traffic from client's upstream socket,
+// re-encapsulates it with the origin address as DST.ADDR/DST.PORT and sends
+// it back to the client through the shared server socket.
+func (a *udpAssociation) pumpReplies(key string, client *udpClient) {
+	buf := make([]byte, a.bufSize)
+	for {
+		n, from, err := client.upstream.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		datagram, err := statute.NewDatagram(from.String(), buf[:n])
+		if err != nil {
+			log.Error("udp associate: build reply datagram from %v failed, err = %v", from, err)
+			continue
+		}
+
+		a.touch(key)
+
+		if _, err := a.serverConn.WriteToUDP(datagram.Bytes(), client.addr); err != nil {
+			log.Error("udp associate: reply to %v failed, err = %v", client.addr, err)
+			return
+		}
+	}
+}
+
+func (a *udpAssociation) touch(key string) {
+	a.mu.Lock()
+	if client, ok := a.clients[key]; ok {
+		client.lastActive = time.Now()
+	}
+	a.mu.Unlock()
+}
+
+// evictWhenIdle closes client's upstream socket once it has seen no traffic
+// for longer than the association's idle timeout.
+func (a *udpAssociation) evictWhenIdle(key string, client *udpClient) {
+	ticker := time.NewTicker(a.timeout / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.mu.Lock()
+		idle := time.Since(client.lastActive) > a.timeout
+		if idle {
+			delete(a.clients, key)
+		}
+		a.mu.Unlock()
+
+		if idle {
+			client.upstream.Close()
+			return
+		}
+	}
+}
+
+// close tears down every client upstream socket still tracked by the
+// association.
+func (a *udpAssociation) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, client := range a.clients {
+		client.upstream.Close()
+		delete(a.clients, key)
+	}
+}
+
+// handleUDPAssociate implements the UDP ASSOCIATE command: it binds a UDP
+// socket on the server's outbound interface, replies with its address, and
+// keeps the TCP control connection open for as long as the association is
+// alive.
+func (s *Server) handleUDPAssociate(conn net.Conn) {
+	serverConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: outboundIP(conn), Port: 0})
+	if err != nil {
+		log.Error("udp associate: listen failed, err = %v", err)
+		conn.Write(genConnDetailRsp(ConnDetailRspRepGeneralServerFailure))
+		return
+	}
+	defer serverConn.Close()
+
+	writeConnDetailRspUDPAssociate(conn, serverConn.LocalAddr().(*net.UDPAddr))
+
+	assoc := newUDPAssociation(serverConn, s.UDPTimeout, s.UDPBufferSize)
+	defer assoc.close()
+	go assoc.relay()
+
+	// The association lives as long as this TCP control connection;
+	// closing it tears down the UDP relay.
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// outboundIP returns the local address this TCP connection was accepted
+// on, used as the interface to bind the client-facing UDP socket to.
+func outboundIP(conn net.Conn) net.IP {
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return net.IPv4zero
+}
+
+func writeConnDetailRspUDPAssociate(conn net.Conn, bnd *net.UDPAddr) {
+	conn.Write(encodeConnDetailRsp(ConnDetailRspRepSucceed, bnd.IP, bnd.Port))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MDGSF/sock5/statute"
+)
+
+func TestUDPAssociationRoundTrip(t *testing.T) {
+	// A fake DNS server that echoes back whatever it receives.
+	fakeDNS, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen fake dns failed: %v", err)
+	}
+	defer fakeDNS.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := fakeDNS.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			fakeDNS.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	serverConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen server conn failed: %v", err)
+	}
+	defer serverConn.Close()
+
+	assoc := newUDPAssociation(serverConn, time.Second, DefaultUDPBufferSize)
+	go assoc.relay()
+	defer assoc.close()
+
+	clientConn, err := net.DialUDP("udp", nil, serverConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("dial client conn failed: %v", err)
+	}
+	defer clientConn.Close()
+
+	query := []byte("dns query")
+	datagram, err := statute.NewDatagram(fakeDNS.LocalAddr().String(), query)
+	if err != nil {
+		t.Fatalf("build datagram failed: %v", err)
+	}
+
+	if _, err := clientConn.Write(datagram.Bytes()); err != nil {
+		t.Fatalf("write datagram failed: %v", err)
+	}
+
+	clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 512)
+	n, err := clientConn.Read(buf)
+	if err != nil {
+		t.Fatalf("read reply failed: %v", err)
+	}
+
+	reply, err := statute.ParseDatagram(buf[:n])
+	if err != nil {
+		t.Fatalf("parse reply failed: %v", err)
+	}
+
+	if string(reply.Data) != string(query) {
+		t.Fatalf("expected echoed query %q, got %q", query, reply.Data)
+	}
+}
+
+func TestWriteConnDetailRspUDPAssociateIPv6(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	bnd := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1080}
+
+	errCh := make(chan struct{})
+	go func() {
+		writeConnDetailRspUDPAssociate(server, bnd)
+		close(errCh)
+	}()
+
+	rsp := make([]byte, 4+net.IPv6len+2)
+	if _, err := io.ReadFull(client, rsp); err != nil {
+		t.Fatalf("read reply failed: %v", err)
+	}
+	<-errCh
+
+	if rsp[3] != ConnDetailRspAtypIPV6 {
+		t.Fatalf("expected IPv6 bind address atyp, got %v", rsp[3])
+	}
+	if !net.IP(rsp[4 : 4+net.IPv6len]).Equal(bnd.IP) {
+		t.Fatalf("expected bind address %v, got %v", bnd.IP, net.IP(rsp[4:4+net.IPv6len]))
+	}
+}